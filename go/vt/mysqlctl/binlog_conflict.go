@@ -0,0 +1,276 @@
+// Copyright 2012, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mysqlctl
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/mysql"
+)
+
+// ConflictPolicy controls how processTransaction (and processBatch) react
+// to a row-level conflict (a duplicate-key error) while replaying a
+// transaction. This happens most often with cross-shard replay during
+// resharding, where a crash between COMMIT and the checkpoint update
+// causes a transaction to be replayed after it was already applied.
+type ConflictPolicy int
+
+const (
+	// ConflictFail aborts replication on any conflict. This is the
+	// default and matches historical behavior.
+	ConflictFail ConflictPolicy = iota
+	// ConflictIgnoreDuplicates silently drops a statement that fails with
+	// a duplicate-key error (1062).
+	ConflictIgnoreDuplicates
+	// ConflictLastWriteWins retries an INSERT that failed with a
+	// duplicate-key error (1062) as INSERT ... ON DUPLICATE KEY UPDATE, so
+	// the replayed statement overwrites the existing row instead of failing.
+	ConflictLastWriteWins
+	// ConflictChecksumAndFail only treats a duplicate-key error on an
+	// INSERT as a harmless replay if the row already in the table
+	// (located by the primary key recorded in the statement's
+	// /* _stream ... */ comment) has, column by column, the exact values
+	// the INSERT was trying to write. It aborts if the comment or an
+	// explicit column list is missing, the row can't be found, or any
+	// column's value has since diverged, rather than silently papering
+	// over what might be an unrelated write to that row.
+	ConflictChecksumAndFail
+)
+
+// streamComment holds the fields parsed out of a /* _stream ... */ comment
+// that the source annotates DML statements with: the table name and the
+// primary key column names/values of the affected row.
+type streamComment struct {
+	table    string
+	pkNames  []string
+	pkValues []string
+}
+
+// parseStreamComment extracts the streamComment appended to sql, if any.
+// The expected format, emitted by the source binlog writer, is:
+//
+//	/* _stream <table> (<col1> <col2> ...) (<val1> <val2> ...); */
+func parseStreamComment(sql string) (*streamComment, bool) {
+	idx := strings.Index(sql, string(BLPL_STREAM_COMMENT_START))
+	if idx < 0 {
+		return nil, false
+	}
+	rest := sql[idx+len(BLPL_STREAM_COMMENT_START):]
+	if end := strings.IndexByte(rest, ';'); end >= 0 {
+		rest = rest[:end]
+	}
+	open1 := strings.IndexByte(rest, '(')
+	if open1 < 0 {
+		return nil, false
+	}
+	table := strings.TrimSpace(rest[:open1])
+	rest = rest[open1:]
+	close1 := strings.IndexByte(rest, ')')
+	if close1 < 0 {
+		return nil, false
+	}
+	names := strings.Fields(rest[1:close1])
+	rest = rest[close1+1:]
+	open2 := strings.IndexByte(rest, '(')
+	if open2 < 0 {
+		return nil, false
+	}
+	close2 := strings.IndexByte(rest, ')')
+	if close2 < open2 {
+		return nil, false
+	}
+	values := strings.Fields(rest[open2+1 : close2])
+	if table == "" || len(names) == 0 || len(names) != len(values) {
+		return nil, false
+	}
+	return &streamComment{table: table, pkNames: names, pkValues: values}, true
+}
+
+// whereClause returns a "col1=val1 AND col2=val2 ..." clause identifying
+// the row sc describes.
+func (sc *streamComment) whereClause() string {
+	conds := make([]string, len(sc.pkNames))
+	for i, name := range sc.pkNames {
+		conds[i] = fmt.Sprintf("%s=%s", name, sc.pkValues[i])
+	}
+	return strings.Join(conds, string(BLPL_SPACE)+"AND"+string(BLPL_SPACE))
+}
+
+// resolveConflict applies blp.conflictPolicy to a duplicate-key (1062)
+// error hit while executing sql. If handled is true, the caller should
+// either skip the statement (skip=true) or retry with newSQL in its
+// place; if handled is false, the policy doesn't cover this error and the
+// original error should be returned to the caller as-is.
+func (blp *BinlogPlayer) resolveConflict(sql string, sqlErr *mysql.SqlError) (newSQL string, skip bool, handled bool) {
+	if sqlErr.Number() != 1062 {
+		return "", false, false
+	}
+	switch blp.conflictPolicy {
+	case ConflictIgnoreDuplicates:
+		log.Infof("ConflictIgnoreDuplicates: dropping statement after duplicate-key error: %v", sqlErr)
+		return "", true, true
+
+	case ConflictLastWriteWins:
+		rewritten, ok := rewriteInsertAsUpsert(sql)
+		if !ok {
+			return "", false, false
+		}
+		log.Infof("ConflictLastWriteWins: rewriting as upsert after duplicate-key error: %v", sqlErr)
+		return rewritten, false, true
+
+	case ConflictChecksumAndFail:
+		sc, ok := parseStreamComment(sql)
+		if !ok {
+			return "", false, false
+		}
+		cols, wantValues, ok := parseInsertColumnsAndValues(sql)
+		if !ok {
+			// Without an explicit column list we can't compare pre-image
+			// values column by column: don't mask the error.
+			return "", false, false
+		}
+		qr, err := blp.exec(fmt.Sprintf("select %s from %s where %s", strings.Join(cols, ", "), sc.table, sc.whereClause()))
+		if err != nil || qr.RowsAffected != 1 {
+			// Either we can't tell, or the conflicting row isn't the one
+			// this statement targeted: don't mask the error.
+			return "", false, false
+		}
+		row := qr.Rows[0]
+		for i, want := range wantValues {
+			want := unquoteSQLLiteral(want)
+			if fmt.Sprintf("%v", row[i]) != want {
+				log.Infof("ConflictChecksumAndFail: row %v diverges on column %v (have %v, pre-image wants %v), aborting", sc.whereClause(), cols[i], row[i], want)
+				return "", false, false
+			}
+		}
+		log.Infof("ConflictChecksumAndFail: row %v matches its pre-image, duplicate-key error is a harmless replay", sc.whereClause())
+		return "", true, true
+
+	default: // ConflictFail
+		return "", false, false
+	}
+}
+
+// rewriteInsertAsUpsert turns "insert into t (c1, c2, ...) values (...)"
+// into the same statement with "ON DUPLICATE KEY UPDATE c1=VALUES(c1), ..."
+// appended, so replaying it after it already succeeded updates the row to
+// the latest values instead of erroring.
+func rewriteInsertAsUpsert(sql string) (string, bool) {
+	lower := strings.ToLower(strings.TrimSpace(sql))
+	if !strings.HasPrefix(lower, "insert") {
+		return "", false
+	}
+	if strings.Contains(lower, "on duplicate key update") {
+		return sql, true
+	}
+	cols, _, ok := parseInsertColumnsAndValues(sql)
+	if !ok {
+		return "", false
+	}
+	updates := make([]string, len(cols))
+	for i, col := range cols {
+		updates[i] = fmt.Sprintf("%s=VALUES(%s)", col, col)
+	}
+	return sql + " ON DUPLICATE KEY UPDATE " + strings.Join(updates, ", "), true
+}
+
+// parseInsertColumnsAndValues parses
+// "insert [ignore] into t (col1, col2, ...) values (val1, val2, ...)"
+// (only the first row of a multi-row VALUES list is read) and returns the
+// column names alongside the literal value tokens, as written in the SQL,
+// in the same order. It returns ok=false if sql has no explicit column
+// list (e.g. "insert into t values (...)"), since callers need the names
+// to line values up with columns and shouldn't guess at them.
+func parseInsertColumnsAndValues(sql string) (cols []string, values []string, ok bool) {
+	lower := strings.ToLower(sql)
+	valuesIdx := strings.Index(lower, "values")
+	if valuesIdx < 0 {
+		return nil, nil, false
+	}
+	head := sql[:valuesIdx]
+	openCols := strings.IndexByte(head, '(')
+	closeCols := strings.LastIndexByte(head, ')')
+	if openCols < 0 || closeCols < openCols {
+		return nil, nil, false
+	}
+	cols = splitAndTrim(head[openCols+1 : closeCols])
+
+	tail := sql[valuesIdx+len("values"):]
+	openVals := strings.IndexByte(tail, '(')
+	if openVals < 0 {
+		return nil, nil, false
+	}
+	closeVals := strings.IndexByte(tail[openVals:], ')')
+	if closeVals < 0 {
+		return nil, nil, false
+	}
+	closeVals += openVals
+	values = splitAndTrim(tail[openVals+1 : closeVals])
+
+	if len(cols) == 0 || len(cols) != len(values) {
+		return nil, nil, false
+	}
+	return cols, values, true
+}
+
+// splitAndTrim splits a comma-separated list and trims whitespace from
+// each element.
+func splitAndTrim(s string) []string {
+	parts := strings.Split(s, ",")
+	out := make([]string, len(parts))
+	for i, p := range parts {
+		out[i] = strings.TrimSpace(p)
+	}
+	return out
+}
+
+// unquoteSQLLiteral strips the surrounding quotes from a single SQL string
+// literal (as lifted verbatim from an INSERT's VALUES list) and undoes its
+// escaping, so it can be compared against a column value read back from the
+// database. Tokens that aren't quoted strings (numbers, NULL) are returned
+// unchanged.
+func unquoteSQLLiteral(s string) string {
+	if len(s) < 2 {
+		return s
+	}
+	quote := s[0]
+	if (quote != '\'' && quote != '"') || s[len(s)-1] != quote {
+		return s
+	}
+	inner := s[1 : len(s)-1]
+	var buf bytes.Buffer
+	for i := 0; i < len(inner); i++ {
+		c := inner[i]
+		switch {
+		case c == '\\' && i+1 < len(inner):
+			i++
+			switch inner[i] {
+			case '0':
+				buf.WriteByte(0)
+			case 'b':
+				buf.WriteByte('\b')
+			case 'n':
+				buf.WriteByte('\n')
+			case 'r':
+				buf.WriteByte('\r')
+			case 't':
+				buf.WriteByte('\t')
+			case 'Z':
+				buf.WriteByte('\x1a')
+			default:
+				buf.WriteByte(inner[i])
+			}
+		case c == quote && i+1 < len(inner) && inner[i+1] == quote:
+			buf.WriteByte(quote)
+			i++
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,147 @@
+// Copyright 2012, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mysqlctl
+
+import (
+	"bytes"
+
+	"github.com/youtube/vitess/go/vt/mysqlctl/proto"
+)
+
+// StatementCategory classifies a single binlog statement for the purpose of
+// event handler routing, based on the statement's SQL text. DML is split
+// into insert/update/delete so handlers can react differently to each.
+type StatementCategory string
+
+const (
+	CategoryDDL       StatementCategory = "ddl"
+	CategoryDMLInsert StatementCategory = "dml-insert"
+	CategoryDMLUpdate StatementCategory = "dml-update"
+	CategoryDMLDelete StatementCategory = "dml-delete"
+	CategoryHeartbeat StatementCategory = "heartbeat"
+	CategoryUnknown   StatementCategory = "unknown"
+)
+
+// HandlerAction is the verdict an EventHandler returns for a statement.
+type HandlerAction int
+
+const (
+	// ActionApply runs the statement unmodified. This is the default.
+	ActionApply HandlerAction = iota
+	// ActionSkip drops the statement without executing it.
+	ActionSkip
+	// ActionRewrite replaces the statement with HandlerResult.RewrittenSql.
+	ActionRewrite
+)
+
+// HandlerResult is returned by an EventHandler to tell BinlogPlayer what to
+// do with the statement it was given.
+type HandlerResult struct {
+	Action       HandlerAction
+	RewrittenSql string
+}
+
+// HandlerContext carries per-transaction position information to
+// EventHandlers, so they can make routing decisions (or their own
+// bookkeeping) without reaching into BinlogPlayer internals.
+type HandlerContext struct {
+	// GroupId is the GroupId of the transaction currently being applied.
+	GroupId int64
+	// LastResumableGroupId is the last GroupId at which it is safe to
+	// resume streaming, e.g. if the process were to crash right now.
+	LastResumableGroupId int64
+}
+
+// EventHandler inspects a single statement and decides how BinlogPlayer
+// should apply it. Handlers are registered per StatementCategory with
+// BinlogPlayer.RegisterEventHandler.
+type EventHandler func(tx *proto.BinlogTransaction, stmt *proto.Statement, ctx *HandlerContext) (HandlerResult, error)
+
+// applyEventHandler is the handler used for every category by default: it
+// preserves BinlogPlayer's historical apply-as-is behavior.
+func applyEventHandler(tx *proto.BinlogTransaction, stmt *proto.Statement, ctx *HandlerContext) (HandlerResult, error) {
+	return HandlerResult{Action: ActionApply}, nil
+}
+
+// defaultEventHandlers returns the handler set that reproduces
+// BinlogPlayer's historical behavior: every statement is applied as-is.
+func defaultEventHandlers() map[StatementCategory]EventHandler {
+	return map[StatementCategory]EventHandler{
+		CategoryDDL:       applyEventHandler,
+		CategoryDMLInsert: applyEventHandler,
+		CategoryDMLUpdate: applyEventHandler,
+		CategoryDMLDelete: applyEventHandler,
+		CategoryHeartbeat: applyEventHandler,
+		CategoryUnknown:   applyEventHandler,
+	}
+}
+
+// RegisterEventHandler installs h as the handler for statements classified
+// as category, replacing any previously registered handler for that
+// category. Passing a nil h restores the default apply-as-is behavior.
+// The zero value of BinlogPlayer has no handlers registered, in which case
+// every statement is applied as-is regardless of category.
+func (blp *BinlogPlayer) RegisterEventHandler(category StatementCategory, h EventHandler) {
+	if blp.eventHandlers == nil {
+		blp.eventHandlers = defaultEventHandlers()
+	}
+	if h == nil {
+		h = applyEventHandler
+	}
+	blp.eventHandlers[category] = h
+}
+
+// classifyStatement runs a light-weight classifier over the statement's SQL
+// so callers don't each have to re-parse it. It looks at the leading
+// keyword, and otherwise classifies any statement that mentions
+// "heartbeat" anywhere as CategoryHeartbeat. It is not a full SQL parser.
+func classifyStatement(stmt *proto.Statement) StatementCategory {
+	sql := bytes.TrimSpace(stmt.Sql)
+	switch {
+	case len(sql) == 0:
+		return CategoryUnknown
+	case hasKeywordPrefix(sql, "create", "alter", "drop", "rename", "truncate"):
+		return CategoryDDL
+	case hasKeywordPrefix(sql, "insert", "replace"):
+		return CategoryDMLInsert
+	case hasKeywordPrefix(sql, "update"):
+		return CategoryDMLUpdate
+	case hasKeywordPrefix(sql, "delete"):
+		return CategoryDMLDelete
+	case bytes.Contains(bytes.ToLower(sql), []byte("heartbeat")):
+		return CategoryHeartbeat
+	default:
+		return CategoryUnknown
+	}
+}
+
+// hasKeywordPrefix returns true if sql starts with one of keywords,
+// case-insensitively.
+func hasKeywordPrefix(sql []byte, keywords ...string) bool {
+	for _, keyword := range keywords {
+		if len(sql) >= len(keyword) && bytes.EqualFold(sql[:len(keyword)], []byte(keyword)) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleStatement runs the registered EventHandler for stmt's category,
+// falling back to apply-as-is if none is registered, and returns the
+// resulting action.
+func (blp *BinlogPlayer) handleStatement(tx *proto.BinlogTransaction, stmt *proto.Statement) (HandlerResult, error) {
+	if blp.eventHandlers == nil {
+		return HandlerResult{Action: ActionApply}, nil
+	}
+	handler, ok := blp.eventHandlers[classifyStatement(stmt)]
+	if !ok {
+		return HandlerResult{Action: ActionApply}, nil
+	}
+	ctx := &HandlerContext{
+		GroupId:              tx.GroupId,
+		LastResumableGroupId: blp.lastResumableGroupId,
+	}
+	return handler(tx, stmt, ctx)
+}
@@ -8,7 +8,7 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
-	"io"
+	"math/rand"
 	"os"
 	"time"
 
@@ -27,6 +27,36 @@ var (
 	BLPL_SPACE                = []byte(" ")
 )
 
+const (
+	// defaultStallTimeout is the default for BinlogPlayer.stallTimeout.
+	defaultStallTimeout = 30 * time.Second
+)
+
+var (
+	// reconnectBaseDelay and reconnectMaxDelay bound the exponential
+	// backoff used between reconnect attempts in ApplyBinlogEvents.
+	reconnectBaseDelay = 1 * time.Second
+	reconnectMaxDelay  = 30 * time.Second
+)
+
+// reconnectBackoffDelay returns a jittered exponential backoff delay for
+// the given 1-indexed reconnect attempt number.
+func reconnectBackoffDelay(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	shift := uint(attempt - 1)
+	if shift > 10 {
+		shift = 10
+	}
+	delay := reconnectBaseDelay * time.Duration(1<<shift)
+	if delay > reconnectMaxDelay {
+		delay = reconnectMaxDelay
+	}
+	// Full jitter: uniformly distributed in [0, delay].
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
 // VtClient is a high level interface to the database
 type VtClient interface {
 	Connect() error
@@ -153,12 +183,20 @@ func (dc *DBClient) ExecuteFetch(query string, maxrows int, wantfields bool) (*m
 
 // blplStats is the internal stats of this player
 type blplStats struct {
-	queryCount    *stats.Counters
-	txnCount      *stats.Counters
-	queriesPerSec *stats.Rates
-	txnsPerSec    *stats.Rates
-	txnTime       *stats.Timings
-	queryTime     *stats.Timings
+	queryCount     *stats.Counters
+	txnCount       *stats.Counters
+	queriesPerSec  *stats.Rates
+	txnsPerSec     *stats.Rates
+	txnTime        *stats.Timings
+	queryTime      *stats.Timings
+	throttledCount *stats.Counters
+	throttledTime  *stats.Timings
+	reconnectCount *stats.Counters
+	stallCount     *stats.Counters
+
+	// lastQueryDuration is how long the most recent exec took. It backs
+	// the query-latency Throttler.
+	lastQueryDuration time.Duration
 }
 
 func NewBlplStats() *blplStats {
@@ -169,6 +207,10 @@ func NewBlplStats() *blplStats {
 	bs.txnsPerSec = stats.NewRates("", bs.txnCount, 15, 60e9)
 	bs.txnTime = stats.NewTimings("")
 	bs.queryTime = stats.NewTimings("")
+	bs.throttledCount = stats.NewCounters("")
+	bs.throttledTime = stats.NewTimings("")
+	bs.reconnectCount = stats.NewCounters("")
+	bs.stallCount = stats.NewCounters("")
 	return bs
 }
 
@@ -182,6 +224,10 @@ func (bs *blplStats) statsJSON() string {
 	fmt.Fprintf(buf, "\n \"TxnPerSec\": %v", bs.txnsPerSec)
 	fmt.Fprintf(buf, "\n \"TxnTime\": %v,", bs.txnTime)
 	fmt.Fprintf(buf, "\n \"QueryTime\": %v,", bs.queryTime)
+	fmt.Fprintf(buf, "\n \"ThrottledCount\": %v,", bs.throttledCount)
+	fmt.Fprintf(buf, "\n \"ThrottledTime\": %v,", bs.throttledTime)
+	fmt.Fprintf(buf, "\n \"ReconnectCount\": %v,", bs.reconnectCount)
+	fmt.Fprintf(buf, "\n \"StallCount\": %v,", bs.stallCount)
 	fmt.Fprintf(buf, "\n}")
 	return buf.String()
 }
@@ -195,6 +241,88 @@ type BinlogPlayer struct {
 	blpPos        proto.BlpPosition
 	stopAtGroupId int64
 	blplStats     *blplStats
+
+	// eventHandlers routes individual statements by StatementCategory.
+	// A nil map (the zero value) means "apply everything as-is", so
+	// existing callers that never touch event handlers are unaffected.
+	eventHandlers map[StatementCategory]EventHandler
+
+	// lastResumableGroupId is the GroupId handlers are told it is safe to
+	// resume streaming from. It only advances once writeResumablePosition
+	// has been called for that GroupId.
+	lastResumableGroupId int64
+
+	// lastTransactionTimestamp is the source-side commit time (in seconds
+	// since the epoch) of the last transaction this player applied. It
+	// drives WaitUntilCaughtUp.
+	lastTransactionTimestamp int64
+
+	// throttler, if set, is consulted between transactions to pace the
+	// apply loop. A nil throttler (the zero value) never throttles.
+	throttler Throttler
+
+	// batchSize is how many consecutive transactions processBatch will
+	// coalesce into a single downstream commit. <= 1 disables batching:
+	// every transaction is applied and checkpointed on its own.
+	batchSize int
+
+	// maxBatchLatency caps how long collectBatch will wait to fill a
+	// batch to batchSize before applying a partial one. 0 means wait
+	// until the batch is full.
+	maxBatchLatency time.Duration
+
+	// maxReconnectAttempts bounds how many times ApplyBinlogEvents will
+	// redial and restart streaming after the connection to the binlog
+	// server is lost. 0 means retry forever.
+	maxReconnectAttempts int
+
+	// stallTimeout is how long ApplyBinlogEvents will wait for any event
+	// before treating the connection as stalled and reconnecting. 0 means
+	// use defaultStallTimeout.
+	stallTimeout time.Duration
+
+	// lastEventAt is when the current stream last delivered a transaction
+	// (or was (re)established, if none yet). It is also reset after each
+	// applied batch and after a throttling pause, so time spent there does
+	// not count as waiting on the source. It drives stall detection.
+	lastEventAt time.Time
+
+	// conflictPolicy governs how row-level conflicts (e.g. 1062 duplicate
+	// key, from replaying a statement that was already applied before a
+	// crash) are resolved. The zero value, ConflictFail, preserves
+	// historical behavior.
+	conflictPolicy ConflictPolicy
+}
+
+// SetThrottler installs t as the Throttler consulted between transactions.
+// Passing nil disables throttling.
+func (blp *BinlogPlayer) SetThrottler(t Throttler) {
+	blp.throttler = t
+}
+
+// SetBatching enables coalescing up to batchSize consecutive transactions
+// into a single downstream commit, waiting at most maxBatchLatency to fill
+// a batch. Passing batchSize <= 1 disables batching.
+func (blp *BinlogPlayer) SetBatching(batchSize int, maxBatchLatency time.Duration) {
+	blp.batchSize = batchSize
+	blp.maxBatchLatency = maxBatchLatency
+}
+
+// SetReconnectParams configures how ApplyBinlogEvents reconnects after
+// losing its connection to the binlog server. maxAttempts caps the number
+// of reconnect attempts (0 means retry forever); stallTimeout is how long
+// to wait for an event before treating the connection as stalled (0 means
+// use defaultStallTimeout).
+func (blp *BinlogPlayer) SetReconnectParams(maxAttempts int, stallTimeout time.Duration) {
+	blp.maxReconnectAttempts = maxAttempts
+	blp.stallTimeout = stallTimeout
+}
+
+// SetConflictPolicy installs the ConflictPolicy processTransaction (and
+// processBatch) use to resolve row-level conflicts. The default,
+// ConflictFail, preserves historical behavior.
+func (blp *BinlogPlayer) SetConflictPolicy(policy ConflictPolicy) {
+	blp.conflictPolicy = policy
 }
 
 // NewBinlogPlayerKeyRange returns a new BinlogPlayer pointing at the server
@@ -203,12 +331,13 @@ type BinlogPlayer struct {
 // If stopAtGroupId != 0, it will stop when reaching that GroupId.
 func NewBinlogPlayerKeyRange(dbClient VtClient, addr string, keyRange key.KeyRange, startPosition *proto.BlpPosition, stopAtGroupId int64) *BinlogPlayer {
 	return &BinlogPlayer{
-		addr:          addr,
-		dbClient:      dbClient,
-		keyRange:      keyRange,
-		blpPos:        *startPosition,
-		stopAtGroupId: stopAtGroupId,
-		blplStats:     NewBlplStats(),
+		addr:                 addr,
+		dbClient:             dbClient,
+		keyRange:             keyRange,
+		blpPos:               *startPosition,
+		stopAtGroupId:        stopAtGroupId,
+		blplStats:            NewBlplStats(),
+		lastResumableGroupId: startPosition.ResumableGroupId,
 	}
 }
 
@@ -218,12 +347,13 @@ func NewBinlogPlayerKeyRange(dbClient VtClient, addr string, keyRange key.KeyRan
 // If stopAtGroupId != 0, it will stop when reaching that GroupId.
 func NewBinlogPlayerTables(dbClient VtClient, addr string, tables []string, startPosition *proto.BlpPosition, stopAtGroupId int64) *BinlogPlayer {
 	return &BinlogPlayer{
-		addr:          addr,
-		dbClient:      dbClient,
-		tables:        tables,
-		blpPos:        *startPosition,
-		stopAtGroupId: stopAtGroupId,
-		blplStats:     NewBlplStats(),
+		addr:                 addr,
+		dbClient:             dbClient,
+		tables:               tables,
+		blpPos:               *startPosition,
+		stopAtGroupId:        stopAtGroupId,
+		blplStats:            NewBlplStats(),
+		lastResumableGroupId: startPosition.ResumableGroupId,
 	}
 }
 
@@ -249,9 +379,84 @@ func (blp *BinlogPlayer) writeRecoveryPosition(groupId int64) error {
 	return nil
 }
 
+// writeResumablePosition records groupId as a safe resumption point: a
+// GroupId that has been fully applied and committed, never one that is
+// mid-DDL or mid-rowset. It must only be called right after a successful
+// Commit.
+func (blp *BinlogPlayer) writeResumablePosition(groupId int64) error {
+	blp.blpPos.ResumableGroupId = groupId
+	blp.lastResumableGroupId = groupId
+	updateResumable := fmt.Sprintf(
+		"update _vt.blp_checkpoint set resumable_group_id=%v where source_shard_uid=%v",
+		groupId,
+		blp.blpPos.Uid)
+
+	qr, err := blp.exec(updateResumable)
+	if err != nil {
+		return fmt.Errorf("Error %v in writing resumable recovery info %v", err, updateResumable)
+	}
+	if qr.RowsAffected != 1 {
+		return fmt.Errorf("Cannot update blp_recovery table, affected %v rows", qr.RowsAffected)
+	}
+	return nil
+}
+
+// LastResumablePosition returns the last GroupId this player is guaranteed
+// to have fully applied, i.e. the position it would resume streaming from
+// after a restart.
+func (blp *BinlogPlayer) LastResumablePosition() proto.BlpPosition {
+	return proto.BlpPosition{
+		Uid:              blp.blpPos.Uid,
+		GroupId:          blp.blpPos.GroupId,
+		ResumableGroupId: blp.lastResumableGroupId,
+	}
+}
+
+// caughtUpThreshold is the default lag WaitUntilCaughtUp waits to fall
+// under before returning true.
+const caughtUpThreshold = 10 * time.Second
+
+// waitUntilCaughtUpPollInterval is how often WaitUntilCaughtUp re-checks
+// replication lag while waiting.
+var waitUntilCaughtUpPollInterval = 100 * time.Millisecond
+
+// WaitUntilCaughtUp blocks until the player's replication lag (the gap
+// between now and the timestamp of the last transaction it applied) is
+// within threshold, then returns true. If threshold is 0, caughtUpThreshold
+// is used. It also returns true once no transaction has arrived from the
+// source for at least threshold, whatever the lag of the last applied one.
+// It returns false if interrupted is closed before either happens.
+func (blp *BinlogPlayer) WaitUntilCaughtUp(threshold time.Duration, interrupted chan struct{}) bool {
+	if threshold == 0 {
+		threshold = caughtUpThreshold
+	}
+	for {
+		now := time.Now()
+		lag := time.Duration(now.Unix()-blp.lastTransactionTimestamp) * time.Second
+		caughtUp := blp.lastTransactionTimestamp > 0 && lag <= threshold
+		if !caughtUp && !blp.lastEventAt.IsZero() && now.Sub(blp.lastEventAt) >= threshold {
+			// The source has gone quiet: no new transaction has arrived in
+			// at least threshold, so there's nothing pending left to apply.
+			// Without this, a caught-up player whose source goes idle would
+			// have lag grow without bound, since it's measured against the
+			// last applied commit's timestamp, and WaitUntilCaughtUp would
+			// block forever even though the player has nothing left to do.
+			caughtUp = true
+		}
+		if caughtUp {
+			return true
+		}
+		select {
+		case <-interrupted:
+			return false
+		case <-time.After(waitUntilCaughtUpPollInterval):
+		}
+	}
+}
+
 func ReadStartPosition(dbClient VtClient, uid uint32) (*proto.BlpPosition, error) {
 	selectRecovery := fmt.Sprintf(
-		"select group_id from _vt.blp_checkpoint where source_shard_uid=%v",
+		"select group_id, resumable_group_id from _vt.blp_checkpoint where source_shard_uid=%v",
 		uid)
 	qr, err := dbClient.ExecuteFetch(selectRecovery, 1, true)
 	if err != nil {
@@ -260,13 +465,18 @@ func ReadStartPosition(dbClient VtClient, uid uint32) (*proto.BlpPosition, error
 	if qr.RowsAffected != 1 {
 		return nil, fmt.Errorf("checkpoint information not available in db for %v", uid)
 	}
-	temp, err := qr.Rows[0][0].ParseInt64()
+	groupId, err := qr.Rows[0][0].ParseInt64()
+	if err != nil {
+		return nil, err
+	}
+	resumableGroupId, err := qr.Rows[0][1].ParseInt64()
 	if err != nil {
 		return nil, err
 	}
 	return &proto.BlpPosition{
-		Uid:     uid,
-		GroupId: temp,
+		Uid:              uid,
+		GroupId:          groupId,
+		ResumableGroupId: resumableGroupId,
 	}, nil
 }
 
@@ -279,10 +489,24 @@ func (blp *BinlogPlayer) processTransaction(tx *proto.BinlogTransaction) (ok boo
 		return false, err
 	}
 	for _, stmt := range tx.Statements {
-		if _, err = blp.exec(string(stmt.Sql)); err == nil {
+		sql := string(stmt.Sql)
+		if blp.eventHandlers != nil {
+			result, herr := blp.handleStatement(tx, &stmt)
+			if herr != nil {
+				return false, herr
+			}
+			switch result.Action {
+			case ActionSkip:
+				continue
+			case ActionRewrite:
+				sql = result.RewrittenSql
+			}
+		}
+		if _, err = blp.exec(sql); err == nil {
 			continue
 		}
-		if sqlErr, ok := err.(*mysql.SqlError); ok && sqlErr.Number() == 1213 {
+		sqlErr, isSQLErr := err.(*mysql.SqlError)
+		if isSQLErr && sqlErr.Number() == 1213 {
 			// Deadlock: ask for retry
 			log.Infof("Deadlock: %v", err)
 			if err = blp.dbClient.Rollback(); err != nil {
@@ -290,22 +514,191 @@ func (blp *BinlogPlayer) processTransaction(tx *proto.BinlogTransaction) (ok boo
 			}
 			return false, nil
 		}
+		if isSQLErr {
+			if rewritten, skip, handled := blp.resolveConflict(sql, sqlErr); handled {
+				if skip {
+					continue
+				}
+				if _, err = blp.exec(rewritten); err == nil {
+					continue
+				}
+			}
+		}
 		return false, err
 	}
 	if err = blp.dbClient.Commit(); err != nil {
 		return false, fmt.Errorf("failed query COMMIT, err: %s", err)
 	}
+	// The transaction is now fully and atomically applied: this is a safe
+	// point to resume streaming from if we crash before the next one.
+	if err = blp.writeResumablePosition(tx.GroupId); err != nil {
+		return false, err
+	}
+	blp.lastTransactionTimestamp = tx.Timestamp
 	blp.blplStats.txnCount.Add("TxnCount", 1)
 	blp.blplStats.txnTime.Record("TxnTime", txnStartTime)
 	return true, nil
 }
 
+// processBatch applies a batch of consecutive transactions within a single
+// downstream MySQL transaction, committing _vt.blp_checkpoint only once for
+// the whole batch. It returns ok=false, err=nil on a retryable deadlock
+// (1213), exactly like processTransaction, in which case the whole batch
+// has been rolled back.
+func (blp *BinlogPlayer) processBatch(batch []*proto.BinlogTransaction) (ok bool, err error) {
+	txnStartTime := time.Now()
+	if err = blp.dbClient.Begin(); err != nil {
+		return false, fmt.Errorf("failed query BEGIN, err: %s", err)
+	}
+	last := batch[len(batch)-1]
+	if err = blp.writeRecoveryPosition(last.GroupId); err != nil {
+		return false, err
+	}
+	for _, tx := range batch {
+		for _, stmt := range tx.Statements {
+			sql := string(stmt.Sql)
+			if blp.eventHandlers != nil {
+				result, herr := blp.handleStatement(tx, &stmt)
+				if herr != nil {
+					return false, herr
+				}
+				switch result.Action {
+				case ActionSkip:
+					continue
+				case ActionRewrite:
+					sql = result.RewrittenSql
+				}
+			}
+			if _, err = blp.exec(sql); err == nil {
+				continue
+			}
+			sqlErr, isSQLErr := err.(*mysql.SqlError)
+			if isSQLErr && sqlErr.Number() == 1213 {
+				// Deadlock: roll back the whole batch and ask for retry.
+				log.Infof("Deadlock in batch of %v transactions: %v", len(batch), err)
+				if err = blp.dbClient.Rollback(); err != nil {
+					return false, err
+				}
+				return false, nil
+			}
+			if isSQLErr {
+				if rewritten, skip, handled := blp.resolveConflict(sql, sqlErr); handled {
+					if skip {
+						continue
+					}
+					if _, err = blp.exec(rewritten); err == nil {
+						continue
+					}
+				}
+			}
+			return false, err
+		}
+	}
+	if err = blp.dbClient.Commit(); err != nil {
+		return false, fmt.Errorf("failed query COMMIT, err: %s", err)
+	}
+	if err = blp.writeResumablePosition(last.GroupId); err != nil {
+		return false, err
+	}
+	blp.lastTransactionTimestamp = last.Timestamp
+	blp.blplStats.txnCount.Add("TxnCount", int64(len(batch)))
+	blp.blplStats.txnTime.Record("TxnTime", txnStartTime)
+	return true, nil
+}
+
+// applyBatch applies batch, retrying on deadlock. A deadlock in a batch of
+// more than one transaction is re-played one transaction at a time to
+// isolate the offending statement; the next batch still uses the
+// configured batchSize, so throughput ramps back up on its own.
+func (blp *BinlogPlayer) applyBatch(batch []*proto.BinlogTransaction) error {
+	ok, err := blp.processBatch(batch)
+	if err != nil {
+		return fmt.Errorf("Error in processing binlog batch %v", err)
+	}
+	if ok {
+		return nil
+	}
+	if len(batch) == 1 {
+		for {
+			ok, err = blp.processTransaction(batch[0])
+			if err != nil {
+				return fmt.Errorf("Error in processing binlog event %v", err)
+			}
+			if ok {
+				return nil
+			}
+			log.Infof("Retrying txn")
+			time.Sleep(1 * time.Second)
+		}
+	}
+	log.Infof("Deadlock in batch of %v, replaying one transaction at a time", len(batch))
+	for _, tx := range batch {
+		if err := blp.applyBatch([]*proto.BinlogTransaction{tx}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectedBatch is the result of collectBatch: the transactions gathered
+// so far, and why collection stopped.
+type collectedBatch struct {
+	batch       []*proto.BinlogTransaction
+	chanClosed  bool
+	interrupted bool
+	// stalled is set if no event arrived for stallTimeout, regardless of
+	// whether a partial batch had already been collected.
+	stalled bool
+}
+
+// collectBatch gathers up to blp.batchSize consecutive transactions from
+// responseChan, stopping early if blp.maxBatchLatency elapses, responseChan
+// is closed, interrupted fires, no event at all has arrived for
+// stallTimeout, or the transaction just added reaches blp.stopAtGroupId.
+// The last case keeps a batch from overshooting the configured cutover
+// point: without it, up to batchSize-1 transactions past stopAtGroupId
+// could be applied before the stopping check in streamAndApply ever runs.
+func (blp *BinlogPlayer) collectBatch(responseChan chan *proto.BinlogTransaction, interrupted chan struct{}, stallTimeout time.Duration) collectedBatch {
+	var deadline <-chan time.Time
+	if blp.maxBatchLatency > 0 {
+		timer := time.NewTimer(blp.maxBatchLatency)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	var batch []*proto.BinlogTransaction
+	for len(batch) < blp.batchSize {
+		remaining := stallTimeout - time.Now().Sub(blp.lastEventAt)
+		if remaining < 0 {
+			remaining = 0
+		}
+		select {
+		case tx, ok := <-responseChan:
+			if !ok {
+				return collectedBatch{batch: batch, chanClosed: true}
+			}
+			blp.lastEventAt = time.Now()
+			batch = append(batch, tx)
+			if blp.stopAtGroupId > 0 && tx.GroupId >= blp.stopAtGroupId {
+				return collectedBatch{batch: batch}
+			}
+		case <-deadline:
+			return collectedBatch{batch: batch}
+		case <-interrupted:
+			return collectedBatch{batch: batch, interrupted: true}
+		case <-time.After(remaining):
+			return collectedBatch{batch: batch, stalled: true}
+		}
+	}
+	return collectedBatch{batch: batch}
+}
+
 func (blp *BinlogPlayer) exec(sql string) (*mproto.QueryResult, error) {
 	queryStartTime := time.Now()
 	qr, err := blp.dbClient.ExecuteFetch(sql, 0, false)
+	blp.blplStats.lastQueryDuration = time.Now().Sub(queryStartTime)
 	blp.blplStats.queryCount.Add("QueryCount", 1)
 	blp.blplStats.queryTime.Record("QueryTime", queryStartTime)
-	if time.Now().Sub(queryStartTime) > SLOW_QUERY_THRESHOLD {
+	if blp.blplStats.lastQueryDuration > SLOW_QUERY_THRESHOLD {
 		log.Infof("SLOW QUERY '%s'", sql)
 	}
 	return qr, err
@@ -314,8 +707,12 @@ func (blp *BinlogPlayer) exec(sql string) (*mproto.QueryResult, error) {
 // ApplyBinlogEvents makes a gob rpc request to BinlogServer
 // and processes the events. It will return nil if 'interrupted'
 // was closed, or if we reached the stopping point.
-// It will return io.EOF if the server stops sending us updates.
-// It may return any other error it encounters.
+// If dialing fails, the stream closes, or no event arrives for too
+// long, it is treated as transient: ApplyBinlogEvents reconnects with
+// exponential backoff and resumes from the last resumable position, up to
+// maxReconnectAttempts times (see SetReconnectParams; 0 means keep trying
+// forever).
+// It may return any other error it encounters applying a transaction.
 func (blp *BinlogPlayer) ApplyBinlogEvents(interrupted chan struct{}) error {
 	if len(blp.tables) > 0 {
 		log.Infof("BinlogPlayer client %v for tables %v starting @ '%v', server: %v",
@@ -344,57 +741,134 @@ func (blp *BinlogPlayer) ApplyBinlogEvents(interrupted chan struct{}) error {
 		}
 		log.Infof("Will stop player when reaching %v", blp.stopAtGroupId)
 	}
+	if blp.batchSize < 1 {
+		blp.batchSize = 1
+	}
+	stallTimeout := blp.stallTimeout
+	if stallTimeout <= 0 {
+		stallTimeout = defaultStallTimeout
+	}
+
+	attempt := 0
+	for {
+		err := blp.streamAndApply(interrupted, stallTimeout)
+		if err == nil {
+			return nil
+		}
+		if _, ok := err.(*streamEndedError); !ok {
+			// Not a stream-level issue (e.g. a bad statement): give up.
+			return err
+		}
+		select {
+		case <-interrupted:
+			return nil
+		default:
+		}
+		attempt++
+		if blp.maxReconnectAttempts > 0 && attempt > blp.maxReconnectAttempts {
+			return fmt.Errorf("giving up after %v reconnect attempts, last error: %v", attempt-1, err)
+		}
+		blp.blplStats.reconnectCount.Add("ReconnectCount", 1)
+		delay := reconnectBackoffDelay(attempt)
+		log.Infof("BinlogPlayer: %v, reconnecting in %v (attempt %v)", err, delay, attempt)
+		select {
+		case <-interrupted:
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
+
+// streamEndedError marks an error as a stream-level problem (failed dial,
+// closed connection, or stall): ApplyBinlogEvents reconnects and resumes
+// from blp.lastResumableGroupId rather than giving up, unless
+// maxReconnectAttempts has been exhausted.
+type streamEndedError struct {
+	reason string
+}
+
+func (e *streamEndedError) Error() string {
+	return e.reason
+}
+
+// streamAndApply dials the binlog server once, streams from
+// blp.lastResumableGroupId, and applies transactions until the stream ends
+// (in which case it returns a *streamEndedError), it is interrupted or
+// reaches stopAtGroupId (nil), or applying a batch fails outright (any
+// other error).
+//
+// Streaming always resumes from lastResumableGroupId rather than
+// blp.blpPos.GroupId, which writeRecoveryPosition advances before a
+// transaction or batch is committed. Within one run the two are equal
+// whenever streamAndApply returns, since a partially collected batch is
+// applied first; they differ when the player starts from a checkpoint whose
+// resumable_group_id lags group_id (a crash between COMMIT and
+// writeResumablePosition), and the transactions after it are then replayed.
+func (blp *BinlogPlayer) streamAndApply(interrupted chan struct{}, stallTimeout time.Duration) error {
 	rpcClient, err := rpcplus.DialHTTP("tcp", blp.addr)
-	defer rpcClient.Close()
 	if err != nil {
 		log.Errorf("Error dialing binlog server: %v", err)
-		return fmt.Errorf("error dialing binlog server: %v", err)
+		return &streamEndedError{fmt.Sprintf("error dialing binlog server: %v", err)}
 	}
+	defer rpcClient.Close()
 
 	responseChan := make(chan *proto.BinlogTransaction)
 	var resp *rpcplus.Call
 	if len(blp.tables) > 0 {
 		req := &proto.TablesRequest{
 			Tables:  blp.tables,
-			GroupId: blp.blpPos.GroupId,
+			GroupId: blp.lastResumableGroupId,
 		}
 		resp = rpcClient.StreamGo("UpdateStream.StreamTables", req, responseChan)
 	} else {
 		req := &proto.KeyRangeRequest{
 			KeyRange: blp.keyRange,
-			GroupId:  blp.blpPos.GroupId,
+			GroupId:  blp.lastResumableGroupId,
 		}
 		resp = rpcClient.StreamGo("UpdateStream.StreamKeyRange", req, responseChan)
 	}
 
+	blp.lastEventAt = time.Now()
+
 processLoop:
 	for {
-		select {
-		case response, ok := <-responseChan:
-			if !ok {
-				break processLoop
+		res := blp.collectBatch(responseChan, interrupted, stallTimeout)
+		if len(res.batch) > 0 {
+			if err := blp.applyBatch(res.batch); err != nil {
+				return err
 			}
-			for {
-				ok, err = blp.processTransaction(response)
-				if err != nil {
-					return fmt.Errorf("Error in processing binlog event %v", err)
-				}
-				if ok {
-					if blp.stopAtGroupId > 0 && blp.blpPos.GroupId >= blp.stopAtGroupId {
-						log.Infof("Reached stopping position, done playing logs")
-						return nil
-					}
-					break
-				}
-				log.Infof("Retrying txn")
-				time.Sleep(1 * time.Second)
+			// Applying a batch isn't waiting on the source, so don't let
+			// time spent applying count against stallTimeout.
+			blp.lastEventAt = time.Now()
+			if blp.stopAtGroupId > 0 && blp.blpPos.GroupId >= blp.stopAtGroupId {
+				log.Infof("Reached stopping position, done playing logs")
+				return nil
 			}
-		case <-interrupted:
+			if blp.throttler != nil {
+				// Pause here, before going back to the top of processLoop
+				// to collect the next batch, so we stop draining
+				// responseChan while throttled.
+				blp.throttler.WaitIfNeeded(interrupted)
+				// Throttling is meant to pause for long stretches (a
+				// replica catching up can take minutes), which is much
+				// longer than stallTimeout: don't let the wait itself be
+				// mistaken for a stalled connection.
+				blp.lastEventAt = time.Now()
+			}
+		}
+		if res.interrupted {
 			return nil
 		}
+		if res.stalled {
+			blp.blplStats.stallCount.Add("StallCount", 1)
+			return &streamEndedError{fmt.Sprintf("no event received for %v, treating connection as stalled", stallTimeout)}
+		}
+		if res.chanClosed {
+			break processLoop
+		}
 	}
 	if resp.Error != nil {
-		return fmt.Errorf("Error received from ServeBinlog %v", resp.Error)
+		return &streamEndedError{fmt.Sprintf("error received from ServeBinlog %v", resp.Error)}
 	}
-	return io.EOF
+	return &streamEndedError{"binlog server stopped sending updates"}
 }
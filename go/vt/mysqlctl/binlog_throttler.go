@@ -0,0 +1,144 @@
+// Copyright 2012, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mysqlctl
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/golang/glog"
+)
+
+// Throttler lets callers slow down or pause a BinlogPlayer's apply loop,
+// e.g. to protect a downstream replica or database from being overwhelmed
+// by many concurrently running BinlogPlayers. Install one with
+// BinlogPlayer.SetThrottler.
+type Throttler interface {
+	// ShouldThrottle reports whether the player should currently pause
+	// applying new transactions, along with a human-readable reason.
+	ShouldThrottle() (bool, string)
+
+	// WaitIfNeeded blocks, re-checking ShouldThrottle, until it is safe to
+	// proceed, or until interrupted is closed.
+	WaitIfNeeded(interrupted chan struct{})
+}
+
+// throttlerPollInterval is how often a Throttler re-checks ShouldThrottle
+// while paused.
+var throttlerPollInterval = 500 * time.Millisecond
+
+// waitIfNeeded is the WaitIfNeeded loop shared by the built-in throttlers:
+// it polls shouldThrottle until it returns false, recording throttle
+// duration and count via bs.
+func waitIfNeeded(shouldThrottle func() (bool, string), bs *blplStats, interrupted chan struct{}) {
+	throttle, reason := shouldThrottle()
+	if !throttle {
+		return
+	}
+	start := time.Now()
+	log.Infof("BinlogPlayer throttling: %v", reason)
+	for {
+		select {
+		case <-interrupted:
+			bs.throttledTime.Record("ThrottledTime", start)
+			return
+		case <-time.After(throttlerPollInterval):
+		}
+		if throttle, reason = shouldThrottle(); !throttle {
+			bs.throttledCount.Add("ThrottledCount", 1)
+			bs.throttledTime.Record("ThrottledTime", start)
+			return
+		}
+	}
+}
+
+// SecondsBehindMasterThrottler throttles based on the target's replication
+// lag, as reported by SHOW SLAVE STATUS's Seconds_Behind_Master column.
+type SecondsBehindMasterThrottler struct {
+	dbClient  VtClient
+	maxLagSec int64
+	stats     *blplStats
+}
+
+// NewSecondsBehindMasterThrottler returns a Throttler that engages whenever
+// the target reports more than maxLagSec of replication lag.
+func NewSecondsBehindMasterThrottler(dbClient VtClient, maxLagSec int64, stats *blplStats) *SecondsBehindMasterThrottler {
+	return &SecondsBehindMasterThrottler{dbClient: dbClient, maxLagSec: maxLagSec, stats: stats}
+}
+
+func (t *SecondsBehindMasterThrottler) ShouldThrottle() (bool, string) {
+	qr, err := t.dbClient.ExecuteFetch("show slave status", 1, true)
+	if err != nil || qr.RowsAffected != 1 {
+		// If we can't read status, don't throttle on its account.
+		return false, ""
+	}
+	idx := -1
+	for i, field := range qr.Fields {
+		if field.Name == "Seconds_Behind_Master" {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return false, ""
+	}
+	lag, err := qr.Rows[0][idx].ParseInt64()
+	if err != nil {
+		return false, ""
+	}
+	if lag > t.maxLagSec {
+		return true, fmt.Sprintf("target is %vs behind master, max allowed is %vs", lag, t.maxLagSec)
+	}
+	return false, ""
+}
+
+func (t *SecondsBehindMasterThrottler) WaitIfNeeded(interrupted chan struct{}) {
+	waitIfNeeded(t.ShouldThrottle, t.stats, interrupted)
+}
+
+// QueryLatencyThrottler throttles based on how long target-side queries
+// have recently been taking to execute.
+type QueryLatencyThrottler struct {
+	stats      *blplStats
+	maxLatency time.Duration
+}
+
+// NewQueryLatencyThrottler returns a Throttler that engages whenever the
+// most recently executed query took longer than maxLatency.
+func NewQueryLatencyThrottler(stats *blplStats, maxLatency time.Duration) *QueryLatencyThrottler {
+	return &QueryLatencyThrottler{stats: stats, maxLatency: maxLatency}
+}
+
+func (t *QueryLatencyThrottler) ShouldThrottle() (bool, string) {
+	if t.stats.lastQueryDuration > t.maxLatency {
+		return true, fmt.Sprintf("last query took %v, max allowed is %v", t.stats.lastQueryDuration, t.maxLatency)
+	}
+	return false, ""
+}
+
+func (t *QueryLatencyThrottler) WaitIfNeeded(interrupted chan struct{}) {
+	waitIfNeeded(t.ShouldThrottle, t.stats, interrupted)
+}
+
+// CustomProbeThrottler throttles based on a user-supplied probe, e.g. a
+// custom SQL query against the target or some external metric.
+type CustomProbeThrottler struct {
+	probe func() (bool, string)
+	stats *blplStats
+}
+
+// NewCustomProbeThrottler returns a Throttler that engages whenever probe
+// returns true.
+func NewCustomProbeThrottler(stats *blplStats, probe func() (bool, string)) *CustomProbeThrottler {
+	return &CustomProbeThrottler{probe: probe, stats: stats}
+}
+
+func (t *CustomProbeThrottler) ShouldThrottle() (bool, string) {
+	return t.probe()
+}
+
+func (t *CustomProbeThrottler) WaitIfNeeded(interrupted chan struct{}) {
+	waitIfNeeded(t.ShouldThrottle, t.stats, interrupted)
+}
@@ -0,0 +1,63 @@
+// Copyright 2012, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package proto defines the structures used for binlog streamer and
+// BinlogPlayer RPCs between a BinlogServer and its clients.
+package proto
+
+import (
+	"github.com/youtube/vitess/go/vt/key"
+)
+
+// BlpPosition stores the recovery position for a BinlogPlayer, as read
+// from / written to _vt.blp_checkpoint.
+type BlpPosition struct {
+	// Uid is the source_shard_uid this position is for.
+	Uid uint32
+
+	// GroupId is the last GroupId this player has seen from the source,
+	// whether or not it has been fully and safely applied yet.
+	GroupId int64
+
+	// ResumableGroupId is the last GroupId the player is guaranteed to
+	// have applied in full. Unlike GroupId, it is only advanced at safe
+	// points (the boundary of a fully committed transaction, never in
+	// the middle of a DDL statement or a batched rowset), so it is the
+	// position to resume streaming from after a crash or restart.
+	ResumableGroupId int64
+}
+
+// Statement is a single statement from a BinlogTransaction, as received
+// from the BinlogServer.
+type Statement struct {
+	// Sql is the raw statement, as it should be replayed against the
+	// target database.
+	Sql []byte
+}
+
+// BinlogTransaction groups all the statements that were part of a single
+// source transaction, identified by GroupId.
+type BinlogTransaction struct {
+	Statements []Statement
+	GroupId    int64
+
+	// Timestamp is the time (in seconds since the epoch) at which this
+	// transaction was committed on the source, as reported by the
+	// BinlogServer. It is used to estimate replication lag.
+	Timestamp int64
+}
+
+// KeyRangeRequest is used to ask a BinlogServer to stream all the
+// transactions affecting the given KeyRange, starting at GroupId.
+type KeyRangeRequest struct {
+	KeyRange key.KeyRange
+	GroupId  int64
+}
+
+// TablesRequest is used to ask a BinlogServer to stream all the
+// transactions affecting the given Tables, starting at GroupId.
+type TablesRequest struct {
+	Tables  []string
+	GroupId int64
+}